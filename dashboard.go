@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/seriousben/proxy-debugger/proxyproto"
+)
+
+// teeingListener wraps a net.Listener so every accepted conn's bytes are
+// also captured for the dashboard's raw-header hex dump, ahead of
+// proxyproto.Listener consuming them to decode a header.
+type teeingListener struct {
+	net.Listener
+}
+
+func (l *teeingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &teeConn{Conn: conn}, nil
+}
+
+// teeConn is a net.Conn that records every byte read from it into buf.
+type teeConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *teeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.buf.Write(b[:n])
+	return n, err
+}
+
+// handleProxyConnection parses the PROXY header(s) off pc, captures a
+// sample of the payload that follows, and publishes the result to h. Unlike
+// the -client round-trip path, this is a one-way tap: nothing is written
+// back to pc.
+func handleProxyConnection(h *hub, pc *proxyproto.Conn) {
+	defer pc.Close()
+
+	var headers []*proxyproto.Header
+	if hdr := pc.Header(); hdr != nil {
+		headers = append(headers, hdr)
+	}
+
+	bufReader := bufio.NewReader(pc)
+	moreHeaders, err := maybeParseProxyProtocols(pc, bufReader, defaultHeaderReadTimeout)
+	if err != nil {
+		log.Println("error parsing PROXY protocol:", err)
+		return
+	}
+	headers = append(headers, moreHeaders...)
+
+	pc.SetReadDeadline(time.Now().Add(defaultHeaderReadTimeout))
+	payload := make([]byte, maxPayloadSample)
+	n, _ := bufReader.Read(payload)
+	payload = payload[:n]
+
+	var rawHeader string
+	if tc, ok := pc.Conn.(*teeConn); ok {
+		rawHeader = fmt.Sprintf("%x", tc.buf.Bytes())
+	}
+
+	h.publish(&event{
+		ID:         h.newID(),
+		Timestamp:  time.Now(),
+		RemoteAddr: pc.RemoteAddr().String(),
+		LocalAddr:  pc.LocalAddr().String(),
+		Headers:    headers,
+		RawHeader:  rawHeader,
+		PayloadHex: fmt.Sprintf("%x", payload),
+	})
+}
+
+var dashboardTpl = template.Must(template.New("dashboard").Parse(`
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>PROXY Protocol Debugger</title>
+		<style>
+			body { font-family: monospace; }
+			table { border-collapse: collapse; width: 100%; }
+			th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+			tr.details { display: none; }
+			tr.details pre { margin: 0; white-space: pre-wrap; word-break: break-all; }
+		</style>
+	</head>
+	<body>
+		<h1>PROXY Protocol Debugger</h1>
+		<p>
+			Filter version: <input id="filterVersion" size="4">
+			Filter address family: <input id="filterAddrType" size="12">
+		</p>
+		<table id="events">
+			<thead>
+				<tr>
+					<th>ID</th>
+					<th>Time</th>
+					<th>Remote</th>
+					<th>Local</th>
+					<th>Version</th>
+					<th>AddrType</th>
+					<th>Command</th>
+					<th>SrcAddr</th>
+					<th>DstAddr</th>
+					<th>TLVs</th>
+				</tr>
+			</thead>
+			<tbody id="eventsBody"></tbody>
+		</table>
+		<script>
+			var eventsBody = document.getElementById("eventsBody");
+			var filterVersion = document.getElementById("filterVersion");
+			var filterAddrType = document.getElementById("filterAddrType");
+
+			function escapeHTML(s) {
+				var div = document.createElement("div");
+				div.textContent = s;
+				return div.innerHTML;
+			}
+
+			// renderTLVs builds a subtable of the decoded TLVs carried by
+			// each header in the chain (ALPN, AUTHORITY, CRC32C, SSL
+			// sub-fields, AWS VPCE, ...), or a placeholder if there are none.
+			function renderTLVs(headers) {
+				var rows = [];
+				(headers || []).forEach(function(h, i) {
+					(h.TLVs || []).forEach(function(tlv) {
+						rows.push(
+							"<tr><td>" + i + "</td>" +
+							"<td>0x" + ("0" + tlv.Type.toString(16)).slice(-2) + "</td>" +
+							"<td>" + escapeHTML(tlv.Name) + "</td>" +
+							"<td>" + escapeHTML(tlv.Value) + "</td></tr>");
+					});
+				});
+				if (rows.length === 0) {
+					return "<p>No TLVs</p>";
+				}
+				return "<table><tr><th>Header</th><th>Type</th><th>Name</th><th>Value</th></tr>" + rows.join("") + "</table>";
+			}
+
+			function matchesFilter(h) {
+				var v = filterVersion.value.trim();
+				var at = filterAddrType.value.trim().toUpperCase();
+				if (v && (!h || h.Version !== v)) { return false; }
+				if (at && (!h || h.AddrType.toUpperCase().indexOf(at) === -1)) { return false; }
+				return true;
+			}
+
+			function applyFilter() {
+				for (var i = 0; i < eventsBody.children.length; i++) {
+					var row = eventsBody.children[i];
+					if (row.classList.contains("details")) { continue; }
+					var show = matchesFilter(row._header);
+					row.style.display = show ? "" : "none";
+					var details = row.nextElementSibling;
+					if (details && details.classList.contains("details") && !show) {
+						details.style.display = "none";
+					}
+				}
+			}
+
+			filterVersion.addEventListener("input", applyFilter);
+			filterAddrType.addEventListener("input", applyFilter);
+
+			function addEvent(ev) {
+				var h = (ev.headers && ev.headers.length > 0) ? ev.headers[0] : null;
+				var row = document.createElement("tr");
+				row._header = h;
+
+				var tlvCount = (ev.headers || []).reduce(function(n, hdr) { return n + (hdr.TLVs || []).length; }, 0);
+				var more = ev.headers && ev.headers.length > 1 ? " (+" + (ev.headers.length - 1) + " more)" : "";
+				row.innerHTML =
+					"<td>" + ev.id + "</td>" +
+					"<td>" + ev.timestamp + "</td>" +
+					"<td>" + ev.remoteAddr + "</td>" +
+					"<td>" + ev.localAddr + "</td>" +
+					"<td>" + (h ? h.Version : "") + more + "</td>" +
+					"<td>" + (h ? h.AddrType : "") + "</td>" +
+					"<td>" + (h ? h.Command : "") + "</td>" +
+					"<td>" + (h ? h.SrcAddr + ":" + h.SrcPort : "") + "</td>" +
+					"<td>" + (h ? h.DstAddr + ":" + h.DstPort : "") + "</td>" +
+					"<td>" + (tlvCount || "") + "</td>";
+				row.style.cursor = "pointer";
+
+				var details = document.createElement("tr");
+				details.className = "details";
+				var cell = document.createElement("td");
+				cell.colSpan = 10;
+				cell.innerHTML =
+					renderTLVs(ev.headers) +
+					"<pre>raw header: " + ev.rawHeader + "\npayload: " + ev.payloadHex + "</pre>";
+				details.appendChild(cell);
+
+				row.addEventListener("click", function() {
+					details.style.display = details.style.display === "table-row" ? "none" : "table-row";
+				});
+
+				eventsBody.appendChild(row);
+				eventsBody.appendChild(details);
+				applyFilter();
+			}
+
+			var proto = window.location.protocol === "https:" ? "wss:" : "ws:";
+			var ws = new WebSocket(proto + "//" + window.location.host + "/ws");
+			ws.onmessage = function(msg) {
+				addEvent(JSON.parse(msg.data));
+			};
+		</script>
+	</body>
+</html>
+`))
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := dashboardTpl.Execute(w, nil); err != nil {
+		log.Println("error generating dashboard template:", err)
+	}
+}
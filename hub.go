@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/seriousben/proxy-debugger/proxyproto"
+)
+
+// maxDashboardEvents bounds the in-memory ring buffer replayed to newly
+// connecting dashboard clients.
+const maxDashboardEvents = 500
+
+// maxPayloadSample bounds how many post-header payload bytes are captured
+// and hex-dumped per event.
+const maxPayloadSample = 256
+
+// event is a single parsed inbound connection, as pushed to dashboard
+// clients over WebSocket.
+type event struct {
+	ID         uint64               `json:"id"`
+	Timestamp  time.Time            `json:"timestamp"`
+	RemoteAddr string               `json:"remoteAddr"`
+	LocalAddr  string               `json:"localAddr"`
+	Headers    []*proxyproto.Header `json:"headers"`
+	RawHeader  string               `json:"rawHeader"`
+	PayloadHex string               `json:"payloadHex"`
+}
+
+// hub fans out events to connected dashboard clients, keeping a bounded
+// ring buffer so a newly connecting browser can be replayed recent history
+// before live streaming begins.
+type hub struct {
+	mu      sync.Mutex
+	nextID  uint64
+	ring    []*event
+	maxRing int
+	clients map[chan *event]struct{}
+}
+
+func newHub(maxRing int) *hub {
+	return &hub{maxRing: maxRing, clients: make(map[chan *event]struct{})}
+}
+
+// nextID returns a monotonically increasing event ID.
+func (h *hub) newID() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	return h.nextID
+}
+
+// publish appends e to the ring buffer and fans it out to all subscribed
+// clients, dropping it for any client whose buffer is full rather than
+// blocking.
+func (h *hub) publish(e *event) {
+	h.mu.Lock()
+	h.ring = append(h.ring, e)
+	if len(h.ring) > h.maxRing {
+		h.ring = h.ring[len(h.ring)-h.maxRing:]
+	}
+	clients := make([]chan *event, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range clients {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// subscribe registers a new client and returns its event channel along with
+// a snapshot of recent history to replay before streaming live events.
+func (h *hub) subscribe() (chan *event, []*event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan *event, 16)
+	h.clients[ch] = struct{}{}
+
+	history := make([]*event, len(h.ring))
+	copy(history, h.ring)
+	return ch, history
+}
+
+func (h *hub) unsubscribe(ch chan *event) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+	close(ch)
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// The dashboard is a debugging tool meant to be reachable from any
+	// browser pointed at it, not a service embedding third-party origins.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// serveWS upgrades the request to a WebSocket and streams events to it:
+// recent history first, then live events as they're published.
+func (h *hub) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ch, history := h.subscribe()
+	defer h.unsubscribe(ch)
+
+	for _, e := range history {
+		if err := conn.WriteJSON(e); err != nil {
+			return
+		}
+	}
+
+	for e := range ch {
+		if err := conn.WriteJSON(e); err != nil {
+			return
+		}
+	}
+}
@@ -2,12 +2,10 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"io"
 	"io/ioutil"
 	"log"
 	"net"
@@ -15,271 +13,239 @@ import (
 	"strconv"
 	"strings"
 	"time"
-)
 
-const protocolV2HeaderLen = 16
-
-var (
-	protocolV1SignatureBytes = []byte("PROXY")
-	protocolV2SignatureBytes = []byte("\x0D\x0A\x0D\x0A\x00\x0D\x0A\x51\x55\x49\x54\x0A")
-
-	htmlTpl = template.Must(template.New("html").Parse(`
-<!DOCTYPE html>
-<html>
-	<head>
-		<meta charset="UTF-8">
-		<title>PROXY Protocol Debugger</title>
-	</head>
-	<body>
-		{{if (gt (len .) 0)}}
-            <table border="1">
-            <tr>
-				<th>Version</th>
-				<th>AddrType</th>
-				<th>SrcAddr</th>
-				<th>DstAddr</th>
-				<th>Transport Protocol (v2)</th>
-				<th>Command (v2)</th>
-            </tr>
-			{{range .}}
-            <tr>
-				<td>{{.Version}}</td>
-				<td>{{.AddrType}}</td>
-				<td>{{.SrcAddr}}:{{.SrcPort}}</td>
-				<td>{{.DstAddr}}:{{.DstPort}}</td>
-				<td>{{.TransportProtocol}}</td>
-				<td>{{.Command}}</td>
-            </tr>
-			{{end}}
-            </table>
-        {{else}}
-            <p>No PROXY protocol header</p>
-		{{end}}
-	</body>
-</html>
-`))
+	"github.com/seriousben/proxy-debugger/proxyproto"
 )
 
-type protocol struct {
-	Version           string
-	Command           string
-	AddrType          string
-	TransportProtocol string
-	SrcAddr           string
-	SrcPort           string
-	DstAddr           string
-	DstPort           string
-}
-
-func parseV1(bufReader *bufio.Reader) (protocol, error) {
-	line, isPrefix, err := bufReader.ReadLine()
-	if err != nil {
-		return protocol{}, fmt.Errorf("v1 readLine error: %w", err)
-	}
-	if isPrefix {
-		return protocol{}, fmt.Errorf("v1 proxy-protocol v1 line too long")
-	}
-
-	// remove \r
-	line = line[:len(line)-1]
-
-	sections := bytes.Split(line, []byte("\x20"))
-	if len(sections) != 6 {
-		return protocol{}, fmt.Errorf("proxy-protocol v1 header corrupted, not enough sections (got: %d, want: %d)", len(sections), 6)
-	}
-
-	return protocol{
-		Version:  "1",
-		AddrType: string(sections[1]),
-		SrcAddr:  string(sections[2]),
-		DstAddr:  string(sections[3]),
-		SrcPort:  string(sections[4]),
-		DstPort:  string(sections[5]),
-	}, nil
-}
+// defaultHeaderReadTimeout bounds how long maybeParseProxyProtocols will
+// block reading any single header off conn.
+const defaultHeaderReadTimeout = 5 * time.Second
 
-func parseV2(sigBytes []byte, bufReader *bufio.Reader) (protocol, error) {
-	if sigBytes[12]>>4 != 0x2 {
-		return protocol{}, errors.New("unknown version of protocol")
-	}
+// maybeParseProxyProtocols reads as many additional chained PROXY headers as
+// are present at the start of bufReader (e.g. a balancer forwarding an
+// already-proxied connection), stopping at the first non-PROXY byte. It is
+// used to parse any headers beyond the first, which proxyproto.Listener
+// already decoded and enforced policy on. The read deadline on conn is
+// reset to timeout before each header, so a legitimate chain of several
+// headers isn't penalized for taking longer than a single header's budget.
+func maybeParseProxyProtocols(conn net.Conn, bufReader *bufio.Reader, timeout time.Duration) ([]*proxyproto.Header, error) {
+	var headers []*proxyproto.Header
 
-	lenField := sigBytes[14:16]
-	lenInt := binary.BigEndian.Uint16(lenField)
-	hdrLenInt := 16 + lenInt
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
 
-	// Consume the whole header
-	line := make([]byte, hdrLenInt)
-	_, err := io.ReadFull(bufReader, line)
-	if err != nil {
-		return protocol{}, err
-	}
+		h, err := proxyproto.ReadHeader(bufReader)
+		if errors.Is(err, proxyproto.ErrNoProxyHeader) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	p := protocol{
-		Version: "2",
+		log.Println(len(headers), "version", h.Version, "command", h.Command)
+		headers = append(headers, h)
 	}
 
-	c := line[12] & 0x01
+	conn.SetReadDeadline(time.Time{})
 
-	switch c {
-	case 0x0:
-		p.Command = "LOCAL"
-	case 0x1:
-		p.Command = "PROXY"
-	default:
-		return protocol{}, errors.New("unknown version 2 command")
-	}
+	return headers, nil
+}
 
-	af := line[13] >> 4
-
-	switch af {
-	case 0x0:
-		p.AddrType = "AF_UNSPEC"
-	case 0x1:
-		p.AddrType = "AF_INET"
-	case 0x2:
-		p.AddrType = "AF_INET6"
-	case 0x3:
-		p.AddrType = "AF_UNIX"
-	default:
-		return protocol{}, errors.New("unknown version 2 address family")
-	}
+// clientConfig holds the -client CLI mode flags used to synthesize a PROXY
+// header ahead of a minimal HTTP request.
+type clientConfig struct {
+	version int
+	cmd     string
+	family  string
+	src     string
+	dst     string
+	tlv     string
+}
 
-	tp := line[13] & 0x01 // is it better to compare `<< 4 == 0x10`?
+// buildClientHeader turns a clientConfig into the proxyproto.Header it
+// describes.
+func buildClientHeader(cfg clientConfig) (proxyproto.Header, error) {
+	h := proxyproto.Header{Command: cfg.cmd}
 
-	switch tp {
-	case 0x0:
-		p.TransportProtocol = "UNSPEC"
-	case 0x1:
-		p.TransportProtocol = "STREAM"
-	case 0x2:
-		p.TransportProtocol = "DGRAM"
+	switch cfg.family {
+	case "unix":
+		if cfg.version == 1 {
+			return proxyproto.Header{}, errors.New("-family unix is only supported with -v 2")
+		}
+		h.AddrType = "AF_UNIX"
+		h.SrcAddr = cfg.src
+		h.DstAddr = cfg.dst
+	case "tcp4", "tcp6":
+		srcHost, srcPort, err := net.SplitHostPort(cfg.src)
+		if err != nil {
+			return proxyproto.Header{}, fmt.Errorf("invalid -src %q: %w", cfg.src, err)
+		}
+		dstHost, dstPort, err := net.SplitHostPort(cfg.dst)
+		if err != nil {
+			return proxyproto.Header{}, fmt.Errorf("invalid -dst %q: %w", cfg.dst, err)
+		}
+		h.SrcAddr, h.SrcPort = srcHost, srcPort
+		h.DstAddr, h.DstPort = dstHost, dstPort
+
+		if cfg.version == 1 {
+			if cfg.family == "tcp4" {
+				h.AddrType = "TCP4"
+			} else {
+				h.AddrType = "TCP6"
+			}
+		} else {
+			if cfg.family == "tcp4" {
+				h.AddrType = "AF_INET"
+			} else {
+				h.AddrType = "AF_INET6"
+			}
+			h.TransportProtocol = "STREAM"
+		}
 	default:
-		return protocol{}, errors.New("unknown version 2 transport protocol")
+		return proxyproto.Header{}, fmt.Errorf("unknown -family %q, want tcp4, tcp6 or unix", cfg.family)
 	}
 
-	switch line[13] {
-	case 0x00:
-		p.SrcAddr = "UNSPEC"
-		p.SrcPort = "UNSPEC"
-		p.DstAddr = "UNSPEC"
-		p.DstPort = "UNSPEC"
-	case 0x11:
-		p.SrcAddr = net.IP(line[16:20]).String()
-		p.DstAddr = net.IP(line[20:24]).String()
-		p.SrcPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[24:26])), 10)
-		p.DstPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[26:28])), 10)
-	case 0x21:
-		p.SrcAddr = net.IP(line[16:32]).String()
-		p.DstAddr = net.IP(line[32:48]).String()
-		p.SrcPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[48:50])), 10)
-		p.DstPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[50:52])), 10)
-	default:
-		return protocol{}, errors.New("unknown version 2 transport protocol")
+	if cfg.version == 2 {
+		tlvs, err := parseTLVFlag(cfg.tlv)
+		if err != nil {
+			return proxyproto.Header{}, err
+		}
+		h.TLVs = tlvs
 	}
 
-	return p, nil
+	return h, nil
 }
 
-func maybeParseProxyProtocols(bufReader *bufio.Reader) ([]protocol, error) {
-	var pps []protocol
+// parseTLVFlag parses a comma-separated "type=hex,..." list, where type is
+// the hex-encoded TLV type byte and hex is the hex-encoded TLV value.
+func parseTLVFlag(s string) ([]proxyproto.TLV, error) {
+	if s == "" {
+		return nil, nil
+	}
 
-	for {
-		sigBytes, err := bufReader.Peek(protocolV2HeaderLen)
-		if err != nil {
-			return nil, fmt.Errorf("peek error: %w", err)
+	var tlvs []proxyproto.TLV
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid -tlv entry %q, want type=hex", pair)
 		}
 
-		isV1 := len(sigBytes) >= len(protocolV1SignatureBytes) && bytes.Equal(sigBytes[:len(protocolV1SignatureBytes)], protocolV1SignatureBytes)
-		isV2 := len(sigBytes) >= protocolV2HeaderLen && bytes.Equal(sigBytes[:len(protocolV2SignatureBytes)], protocolV2SignatureBytes)
-
-		log.Println(len(pps), "isV1", isV1, "isV2", isV2)
-
-		var p protocol
-		if isV1 {
-			p, err = parseV1(bufReader)
-		} else if isV2 {
-			p, err = parseV2(sigBytes, bufReader)
-		} else {
-			break
+		t, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLV type %q: %w", parts[0], err)
 		}
 
+		value, err := hex.DecodeString(parts[1])
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid TLV hex value %q: %w", parts[1], err)
 		}
-		pps = append(pps, p)
+
+		tlvs = append(tlvs, proxyproto.TLV{Type: uint8(t), Value: string(value)})
 	}
 
-	return pps, nil
+	return tlvs, nil
 }
 
-func createResponse(req *http.Request, content string) *http.Response {
-	return &http.Response{
-		Status:        "200 OK",
-		StatusCode:    200,
-		Proto:         "HTTP/1.0",
-		ProtoMajor:    1,
-		ProtoMinor:    0,
-		Request:       req,
-		Close:         true,
-		Body:          ioutil.NopCloser(strings.NewReader(content)),
-		ContentLength: int64(len(content)),
+// runClient dials addr, sends a synthesized PROXY header followed by a
+// minimal HTTP request, and prints the response.
+func runClient(addr string, cfg clientConfig) error {
+	h, err := buildClientHeader(cfg)
+	if err != nil {
+		return err
 	}
-}
-
-func handleConnection(conn net.Conn) {
-	log.Println("Handling new connection...")
-
-	// Close connection when this function ends
-	defer func() {
-		log.Println("Closing connection...")
-		conn.Close()
-	}()
-
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	bufReader := bufio.NewReader(conn)
 
-	pps, err := maybeParseProxyProtocols(bufReader)
+	var headerBytes []byte
+	switch cfg.version {
+	case 1:
+		headerBytes, err = proxyproto.EncodeV1(h)
+	case 2:
+		headerBytes, err = proxyproto.EncodeV2(h)
+	default:
+		return fmt.Errorf("unsupported -v %d, want 1 or 2", cfg.version)
+	}
 	if err != nil {
-		log.Println("error parsing PROXY protocol:", err)
-		return
+		return err
 	}
 
-	req, err := http.ReadRequest(bufReader)
+	conn, err := net.Dial("tcp", addr)
 	if err != nil {
-		log.Println("error reading HTTP request:", err)
-		return
+		return err
 	}
+	defer conn.Close()
 
-	var buf bytes.Buffer
-	if err := htmlTpl.Execute(&buf, pps); err != nil {
-		log.Println("error generating HTML template:", err)
-		return
+	if _, err := conn.Write(headerBytes); err != nil {
+		return fmt.Errorf("writing PROXY header: %w", err)
 	}
 
-	res := createResponse(req, buf.String())
+	req := fmt.Sprintf("GET / HTTP/1.0\r\nHost: %s\r\n\r\n", addr)
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return fmt.Errorf("writing HTTP request: %w", err)
+	}
 
-	err = res.Write(conn)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
 	if err != nil {
-		log.Println("error writing HTTP response:", err)
-		return
+		return fmt.Errorf("reading HTTP response: %w", err)
 	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading HTTP response body: %w", err)
+	}
+
+	fmt.Println(resp.Status)
+	fmt.Println(string(body))
+
+	return nil
 }
 
 func main() {
-	// listen on port
-	l, err := net.Listen("tcp", ":8080")
+	clientAddr := flag.String("client", "", "dial addr, send a synthesized PROXY header plus a minimal HTTP request, and print the response")
+	version := flag.Int("v", 2, "PROXY protocol version to send in -client mode (1 or 2)")
+	cmd := flag.String("cmd", "PROXY", "PROXY protocol command to send in -client mode (PROXY or LOCAL)")
+	family := flag.String("family", "tcp4", "address family to send in -client mode (tcp4, tcp6 or unix)")
+	src := flag.String("src", "", "source address to send in -client mode (host:port, or a path when -family unix)")
+	dst := flag.String("dst", "", "destination address to send in -client mode (host:port, or a path when -family unix)")
+	tlv := flag.String("tlv", "", "comma-separated type=hex TLVs to attach in -client mode (v2 only), e.g. 01=6832")
+	listenAddr := flag.String("listen", ":8080", "address the live dashboard (HTML + WebSocket) listens on")
+	proxyListenAddr := flag.String("proxy-listen", ":8081", "address that accepts raw PROXY protocol connections")
+	flag.Parse()
+
+	if *clientAddr != "" {
+		cfg := clientConfig{version: *version, cmd: *cmd, family: *family, src: *src, dst: *dst, tlv: *tlv}
+		if err := runClient(*clientAddr, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	h := newHub(maxDashboardEvents)
+
+	proxyListener, err := net.Listen("tcp", *proxyListenAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer l.Close()
+	defer proxyListener.Close()
 
-	for {
-		// Wait for a connection.
-		conn, err := l.Accept()
-		if err != nil {
-			log.Fatal(err)
+	pl := &proxyproto.Listener{
+		Listener:          &teeingListener{Listener: proxyListener},
+		ReadHeaderTimeout: defaultHeaderReadTimeout,
+	}
+
+	go func() {
+		for {
+			conn, err := pl.Accept()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			go handleProxyConnection(h, conn.(*proxyproto.Conn))
 		}
+	}()
 
-		go handleConnection(conn)
-	}
+	http.HandleFunc("/", serveDashboard)
+	http.HandleFunc("/ws", h.serveWS)
+
+	log.Printf("dashboard listening on %s, PROXY protocol listening on %s", *listenAddr, *proxyListenAddr)
+	log.Fatal(http.ListenAndServe(*listenAddr, nil))
 }
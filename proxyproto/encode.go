@@ -0,0 +1,191 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+)
+
+// AppendCRC32C, when set on a Header passed to EncodeV2, tells it to append
+// a PP2_TYPE_CRC32C TLV computed over the complete encoded header (with the
+// TLV's own value field zeroed), matching the verification done by
+// decodeTLVs.
+//
+// EncodeV1 and EncodeV2 build raw PROXY protocol header bytes from a
+// Header, the mirror image of ReadHeader. They're meant for synthesizing
+// test traffic (see the -client CLI mode), not for re-encoding a Header
+// produced by ReadHeader: most decoded TLV values are rendered for display
+// (hex dumps, formatted SSL fields, ...) rather than kept as the literal
+// wire bytes. Callers building a Header to encode should set TLV.Value to
+// the literal bytes they want on the wire.
+
+// EncodeV1 builds a v1 (text) PROXY protocol header line from h.
+func EncodeV1(h Header) ([]byte, error) {
+	if h.AddrType == "UNKNOWN" || h.AddrType == "" {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+	if h.AddrType != "TCP4" && h.AddrType != "TCP6" {
+		return nil, fmt.Errorf("proxyproto: v1 AddrType must be TCP4, TCP6 or UNKNOWN, got %q", h.AddrType)
+	}
+	if h.SrcAddr == "" || h.DstAddr == "" || h.SrcPort == "" || h.DstPort == "" {
+		return nil, fmt.Errorf("proxyproto: v1 %s header requires SrcAddr, DstAddr, SrcPort and DstPort", h.AddrType)
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %s %s\r\n", h.AddrType, h.SrcAddr, h.DstAddr, h.SrcPort, h.DstPort)), nil
+}
+
+// EncodeV2 builds a v2 (binary) PROXY protocol header from h, including any
+// TLVs attached via h.TLVs, and appends a PP2_TYPE_CRC32C TLV when
+// h.AppendCRC32C is set.
+func EncodeV2(h Header) ([]byte, error) {
+	var verCmd byte
+	switch h.Command {
+	case "", "PROXY":
+		verCmd = 0x20 | 0x1
+	case "LOCAL":
+		verCmd = 0x20 | 0x0
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown v2 command %q", h.Command)
+	}
+
+	var af byte
+	switch h.AddrType {
+	case "AF_INET":
+		af = 0x1
+	case "AF_INET6":
+		af = 0x2
+	case "AF_UNIX":
+		af = 0x3
+	case "", "AF_UNSPEC":
+		af = 0x0
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown v2 address family %q", h.AddrType)
+	}
+
+	var tp byte
+	switch h.TransportProtocol {
+	case "", "STREAM":
+		tp = 0x1
+	case "DGRAM":
+		tp = 0x2
+	case "UNSPEC":
+		tp = 0x0
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown v2 transport protocol %q", h.TransportProtocol)
+	}
+	afTp := af<<4 | tp
+
+	addr, err := encodeV2AddressBlock(h, af)
+	if err != nil {
+		return nil, err
+	}
+
+	tlvs, err := encodeTLVs(h.TLVs)
+	if err != nil {
+		return nil, err
+	}
+
+	crcOffset := -1
+	if h.AppendCRC32C {
+		crcOffset = 16 + len(addr) + len(tlvs) + 3
+		tlvs = append(tlvs, encodeTLV(pp2TypeCRC32C, make([]byte, 4))...)
+	}
+
+	header := make([]byte, 0, 16+len(addr)+len(tlvs))
+	header = append(header, protocolV2SignatureBytes...)
+	header = append(header, verCmd, afTp)
+
+	lenField := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenField, uint16(len(addr)+len(tlvs)))
+	header = append(header, lenField...)
+	header = append(header, addr...)
+	header = append(header, tlvs...)
+
+	if crcOffset >= 0 {
+		crc := crc32.Checksum(header, crc32cTable)
+		binary.BigEndian.PutUint32(header[crcOffset:crcOffset+4], crc)
+	}
+
+	return header, nil
+}
+
+func encodeV2AddressBlock(h Header, af byte) ([]byte, error) {
+	switch af {
+	case 0x0:
+		return nil, nil
+	case 0x1:
+		srcIP := net.ParseIP(h.SrcAddr).To4()
+		dstIP := net.ParseIP(h.DstAddr).To4()
+		if srcIP == nil || dstIP == nil {
+			return nil, fmt.Errorf("proxyproto: invalid IPv4 SrcAddr/DstAddr %q/%q", h.SrcAddr, h.DstAddr)
+		}
+		srcPort, dstPort, err := parsePorts(h.SrcPort, h.DstPort)
+		if err != nil {
+			return nil, err
+		}
+		addr := make([]byte, 12)
+		copy(addr[0:4], srcIP)
+		copy(addr[4:8], dstIP)
+		binary.BigEndian.PutUint16(addr[8:10], srcPort)
+		binary.BigEndian.PutUint16(addr[10:12], dstPort)
+		return addr, nil
+	case 0x2:
+		srcIP := net.ParseIP(h.SrcAddr).To16()
+		dstIP := net.ParseIP(h.DstAddr).To16()
+		if srcIP == nil || dstIP == nil {
+			return nil, fmt.Errorf("proxyproto: invalid IPv6 SrcAddr/DstAddr %q/%q", h.SrcAddr, h.DstAddr)
+		}
+		srcPort, dstPort, err := parsePorts(h.SrcPort, h.DstPort)
+		if err != nil {
+			return nil, err
+		}
+		addr := make([]byte, 36)
+		copy(addr[0:16], srcIP)
+		copy(addr[16:32], dstIP)
+		binary.BigEndian.PutUint16(addr[32:34], srcPort)
+		binary.BigEndian.PutUint16(addr[34:36], dstPort)
+		return addr, nil
+	case 0x3:
+		if len(h.SrcAddr) > 108 || len(h.DstAddr) > 108 {
+			return nil, fmt.Errorf("proxyproto: UNIX socket path longer than 108 bytes")
+		}
+		addr := make([]byte, 216)
+		copy(addr[0:108], h.SrcAddr)
+		copy(addr[108:216], h.DstAddr)
+		return addr, nil
+	default:
+		return nil, fmt.Errorf("proxyproto: unsupported address family 0x%x", af)
+	}
+}
+
+func parsePorts(srcPort, dstPort string) (uint16, uint16, error) {
+	src, err := strconv.ParseUint(srcPort, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("proxyproto: invalid SrcPort %q: %w", srcPort, err)
+	}
+	dst, err := strconv.ParseUint(dstPort, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("proxyproto: invalid DstPort %q: %w", dstPort, err)
+	}
+	return uint16(src), uint16(dst), nil
+}
+
+func encodeTLVs(tlvs []TLV) ([]byte, error) {
+	var out []byte
+	for _, tlv := range tlvs {
+		out = append(out, encodeTLV(tlv.Type, []byte(tlv.Value))...)
+	}
+	return out, nil
+}
+
+func encodeTLV(t uint8, value []byte) []byte {
+	out := make([]byte, 0, 3+len(value))
+	lenField := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenField, uint16(len(value)))
+	out = append(out, t)
+	out = append(out, lenField...)
+	out = append(out, value...)
+	return out
+}
@@ -0,0 +1,93 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecodeV1RoundTrip(t *testing.T) {
+	want := Header{
+		AddrType: "TCP4",
+		SrcAddr:  "10.0.0.1",
+		DstAddr:  "10.0.0.2",
+		SrcPort:  "8080",
+		DstPort:  "80",
+	}
+
+	raw, err := EncodeV1(want)
+	if err != nil {
+		t.Fatalf("EncodeV1() error = %v", err)
+	}
+
+	got, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+
+	if got.SrcAddr != want.SrcAddr || got.DstAddr != want.DstAddr || got.SrcPort != want.SrcPort || got.DstPort != want.DstPort {
+		t.Errorf("round trip mismatch: got %+v, want matching fields of %+v", got, want)
+	}
+}
+
+func TestEncodeDecodeV2RoundTrip(t *testing.T) {
+	h := Header{
+		Command:           "PROXY",
+		AddrType:          "AF_INET",
+		TransportProtocol: "STREAM",
+		SrcAddr:           "10.0.0.1",
+		DstAddr:           "10.0.0.2",
+		SrcPort:           "8080",
+		DstPort:           "80",
+		TLVs: []TLV{
+			{Type: pp2TypeAuthority, Value: "example.com"},
+		},
+	}
+
+	raw, err := EncodeV2(h)
+	if err != nil {
+		t.Fatalf("EncodeV2() error = %v", err)
+	}
+
+	got, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+
+	if got.SrcAddr != h.SrcAddr || got.DstAddr != h.DstAddr || got.SrcPort != h.SrcPort || got.DstPort != h.DstPort {
+		t.Errorf("address round trip mismatch: got %+v", got)
+	}
+	if len(got.TLVs) != 1 || got.TLVs[0].Name != "AUTHORITY" || got.TLVs[0].Value != "example.com" {
+		t.Errorf("TLV round trip mismatch: got %+v", got.TLVs)
+	}
+}
+
+func TestEncodeV2AppendCRC32C(t *testing.T) {
+	h := Header{
+		Command:           "PROXY",
+		AddrType:          "AF_INET",
+		TransportProtocol: "STREAM",
+		SrcAddr:           "10.0.0.1",
+		DstAddr:           "10.0.0.2",
+		SrcPort:           "8080",
+		DstPort:           "80",
+		AppendCRC32C:      true,
+	}
+
+	raw, err := EncodeV2(h)
+	if err != nil {
+		t.Fatalf("EncodeV2() error = %v", err)
+	}
+
+	got, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+
+	if len(got.TLVs) != 1 || got.TLVs[0].Name != "CRC32C" {
+		t.Fatalf("expected a single CRC32C TLV, got %+v", got.TLVs)
+	}
+	if bytes.Contains([]byte(got.TLVs[0].Value), []byte("MISMATCH")) {
+		t.Errorf("CRC32C TLV reported a mismatch: %s", got.TLVs[0].Value)
+	}
+}
@@ -0,0 +1,485 @@
+// Package proxyproto implements parsing, encoding and net.Listener
+// integration for the HAProxy PROXY protocol (v1 and v2).
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+)
+
+const protocolV2HeaderLen = 16
+
+// DefaultMaxHeaderBytes bounds the total size of a v2 header (signature plus
+// TLVs) that ReadHeader will allocate a buffer for, used when ReadHeader's
+// caller doesn't need a tighter limit. It rejects a malicious length field
+// before it can make the server allocate an oversized (up to 64KB) buffer
+// per connection.
+const DefaultMaxHeaderBytes = 4096
+
+// ErrNoProxyHeader is returned by ReadHeader when the bytes at the start of
+// the stream are neither a v1 nor a v2 PROXY protocol signature.
+var ErrNoProxyHeader = errors.New("proxyproto: no PROXY protocol header")
+
+// PP2 TLV types, as defined by the PROXY protocol v2 spec.
+const (
+	pp2TypeALPN      = 0x01
+	pp2TypeAuthority = 0x02
+	pp2TypeCRC32C    = 0x03
+	pp2TypeNOOP      = 0x04
+	pp2TypeUniqueID  = 0x05
+	pp2TypeSSL       = 0x20
+	pp2TypeNetNS     = 0x30
+	pp2TypeAWS       = 0xEA
+
+	pp2SubtypeSSLVersion = 0x21
+	pp2SubtypeSSLCN      = 0x22
+	pp2SubtypeSSLCipher  = 0x23
+	pp2SubtypeSSLSigAlg  = 0x24
+	pp2SubtypeSSLKeyAlg  = 0x25
+
+	pp2AWSSubtypeVPCE = 0x01
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	protocolV1SignatureBytes = []byte("PROXY")
+	protocolV2SignatureBytes = []byte("\x0D\x0A\x0D\x0A\x00\x0D\x0A\x51\x55\x49\x54\x0A")
+)
+
+// TLV is a decoded PROXY protocol v2 Type-Length-Value record.
+type TLV struct {
+	Type  uint8
+	Name  string
+	Value string
+}
+
+// Header is a decoded PROXY protocol header, v1 or v2.
+type Header struct {
+	Version           string
+	Command           string
+	AddrType          string
+	TransportProtocol string
+	SrcAddr           string
+	SrcPort           string
+	DstAddr           string
+	DstPort           string
+	TLVs              []TLV
+
+	// RawAddr holds the hex-encoded address block for combinations whose
+	// layout isn't defined by the spec (AF_UNSPEC carrying a non-empty
+	// address block, as seen on some health-check connections). It is
+	// empty whenever SrcAddr/DstAddr could be decoded normally.
+	RawAddr string
+
+	// AppendCRC32C tells EncodeV2 to append a PP2_TYPE_CRC32C TLV computed
+	// over the encoded header. It is ignored by ReadHeader/parseV2.
+	AppendCRC32C bool
+
+	// Src and Dst are the same addresses as SrcAddr/SrcPort and
+	// DstAddr/DstPort, decoded as net.Addr for consumers that want to use
+	// them directly (e.g. Listener).
+	Src net.Addr
+	Dst net.Addr
+}
+
+// ReadHeader reads a single PROXY protocol header (v1 or v2) from
+// bufReader. It returns ErrNoProxyHeader, without consuming any bytes, if
+// the stream does not start with a recognized signature. It is equivalent
+// to ReadHeaderLimit with maxHeaderBytes set to DefaultMaxHeaderBytes.
+func ReadHeader(bufReader *bufio.Reader) (*Header, error) {
+	return ReadHeaderLimit(bufReader, DefaultMaxHeaderBytes)
+}
+
+// ReadHeaderLimit is like ReadHeader, but rejects a v2 header whose declared
+// length exceeds maxHeaderBytes instead of allocating a buffer for it.
+//
+// It peeks progressively (5 bytes, then 12, then the full 16-byte v2
+// signature) rather than all at once, so that a connection carrying no
+// PROXY header at all — including a legitimate request shorter than 16
+// bytes, such as "GET / HTTP/1.0\r\n" — is recognized as ErrNoProxyHeader as
+// soon as its prefix stops matching either signature, instead of blocking
+// until either 16 bytes arrive or the read deadline expires. An io.EOF hit
+// at any of these peeks (the stream ending before a signature could be
+// ruled in or out) is also reported as ErrNoProxyHeader; any other read
+// error is returned as-is.
+func ReadHeaderLimit(bufReader *bufio.Reader, maxHeaderBytes int) (*Header, error) {
+	prefix, err := peekSignature(bufReader, len(protocolV1SignatureBytes))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, protocolV1SignatureBytes) {
+		return parseV1(bufReader)
+	}
+	if !bytes.HasPrefix(protocolV2SignatureBytes, prefix) {
+		return nil, ErrNoProxyHeader
+	}
+
+	prefix, err = peekSignature(bufReader, 12)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(protocolV2SignatureBytes, prefix) {
+		return nil, ErrNoProxyHeader
+	}
+
+	sigBytes, err := peekSignature(bufReader, protocolV2HeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sigBytes[:len(protocolV2SignatureBytes)], protocolV2SignatureBytes) {
+		return nil, ErrNoProxyHeader
+	}
+
+	return parseV2(sigBytes, bufReader, maxHeaderBytes)
+}
+
+// peekSignature peeks n bytes off bufReader without consuming them. A
+// stream ending before n bytes arrive (io.EOF or io.ErrUnexpectedEOF) isn't
+// a parse error: it just means there's no PROXY header, so it's reported as
+// ErrNoProxyHeader. Any other error (e.g. a read deadline exceeded while
+// more bytes are still expected) is a real error and is returned as-is.
+func peekSignature(bufReader *bufio.Reader, n int) ([]byte, error) {
+	b, err := bufReader.Peek(n)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNoProxyHeader
+		}
+		return nil, fmt.Errorf("peek error: %w", err)
+	}
+	return b, nil
+}
+
+func parseV1(bufReader *bufio.Reader) (*Header, error) {
+	line, isPrefix, err := bufReader.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("v1 readLine error: %w", err)
+	}
+	if isPrefix {
+		return nil, fmt.Errorf("v1 proxy-protocol v1 line too long")
+	}
+
+	sections := bytes.Split(line, []byte("\x20"))
+	if len(sections) != 6 {
+		return nil, fmt.Errorf("proxy-protocol v1 header corrupted, not enough sections (got: %d, want: %d)", len(sections), 6)
+	}
+
+	h := &Header{
+		Version:  "1",
+		AddrType: string(sections[1]),
+		SrcAddr:  string(sections[2]),
+		DstAddr:  string(sections[3]),
+		SrcPort:  string(sections[4]),
+		DstPort:  string(sections[5]),
+	}
+	h.Src = tcpAddr(h.SrcAddr, h.SrcPort)
+	h.Dst = tcpAddr(h.DstAddr, h.DstPort)
+
+	return h, nil
+}
+
+func parseV2(sigBytes []byte, bufReader *bufio.Reader, maxHeaderBytes int) (*Header, error) {
+	if sigBytes[12]>>4 != 0x2 {
+		return nil, errors.New("unknown version of protocol")
+	}
+
+	lenField := sigBytes[14:16]
+	lenInt := binary.BigEndian.Uint16(lenField)
+	hdrLenInt := 16 + lenInt
+
+	if int(hdrLenInt) > maxHeaderBytes {
+		return nil, fmt.Errorf("proxyproto: v2 header length %d exceeds max of %d bytes", hdrLenInt, maxHeaderBytes)
+	}
+
+	// Consume the whole header
+	line := make([]byte, hdrLenInt)
+	_, err := io.ReadFull(bufReader, line)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Header{
+		Version: "2",
+	}
+
+	c := line[12] & 0x01
+
+	switch c {
+	case 0x0:
+		h.Command = "LOCAL"
+	case 0x1:
+		h.Command = "PROXY"
+	default:
+		return nil, errors.New("unknown version 2 command")
+	}
+
+	af := line[13] >> 4
+
+	switch af {
+	case 0x0:
+		h.AddrType = "AF_UNSPEC"
+	case 0x1:
+		h.AddrType = "AF_INET"
+	case 0x2:
+		h.AddrType = "AF_INET6"
+	case 0x3:
+		h.AddrType = "AF_UNIX"
+	default:
+		return nil, errors.New("unknown version 2 address family")
+	}
+
+	tp := line[13] & 0x01 // is it better to compare `<< 4 == 0x10`?
+
+	switch tp {
+	case 0x0:
+		h.TransportProtocol = "UNSPEC"
+	case 0x1:
+		h.TransportProtocol = "STREAM"
+	case 0x2:
+		h.TransportProtocol = "DGRAM"
+	default:
+		return nil, errors.New("unknown version 2 transport protocol")
+	}
+
+	var addrBlockSize int
+	var opaqueAddr bool
+
+	switch line[13] {
+	case 0x00, 0x01, 0x02:
+		// AF_UNSPEC, as seen on health-check connections. The layout of
+		// any trailing bytes isn't defined by the spec, so don't attempt
+		// to decode an address block or TLVs from them.
+		h.SrcAddr = "UNSPEC"
+		h.SrcPort = "UNSPEC"
+		h.DstAddr = "UNSPEC"
+		h.DstPort = "UNSPEC"
+		addrBlockSize = 0
+		opaqueAddr = true
+	case 0x11:
+		addrBlockSize = 12
+		if len(line) < 16+addrBlockSize {
+			return nil, fmt.Errorf("proxy-protocol v2 header too short for AF_INET address block (got %d, want >= %d)", len(line), 16+addrBlockSize)
+		}
+		h.SrcAddr = net.IP(line[16:20]).String()
+		h.DstAddr = net.IP(line[20:24]).String()
+		h.SrcPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[24:26])), 10)
+		h.DstPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[26:28])), 10)
+		h.Src = tcpAddr(h.SrcAddr, h.SrcPort)
+		h.Dst = tcpAddr(h.DstAddr, h.DstPort)
+	case 0x21:
+		addrBlockSize = 36
+		if len(line) < 16+addrBlockSize {
+			return nil, fmt.Errorf("proxy-protocol v2 header too short for AF_INET6 address block (got %d, want >= %d)", len(line), 16+addrBlockSize)
+		}
+		h.SrcAddr = net.IP(line[16:32]).String()
+		h.DstAddr = net.IP(line[32:48]).String()
+		h.SrcPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[48:50])), 10)
+		h.DstPort = strconv.FormatUint(uint64(binary.BigEndian.Uint16(line[50:52])), 10)
+		h.Src = tcpAddr(h.SrcAddr, h.SrcPort)
+		h.Dst = tcpAddr(h.DstAddr, h.DstPort)
+	case 0x31, 0x32:
+		addrBlockSize = 216
+		if len(line) < 16+addrBlockSize {
+			return nil, fmt.Errorf("proxy-protocol v2 header too short for AF_UNIX address block (got %d, want >= %d)", len(line), 16+addrBlockSize)
+		}
+		srcPath := trimNULs(line[16:124])
+		dstPath := trimNULs(line[124:232])
+		h.SrcAddr = srcPath
+		h.DstAddr = dstPath
+		h.SrcPort = "-"
+		h.DstPort = "-"
+		h.Src = unixAddr(srcPath)
+		h.Dst = unixAddr(dstPath)
+	default:
+		return nil, errors.New("unknown version 2 transport protocol")
+	}
+
+	if opaqueAddr {
+		if len(line) > 16 {
+			h.RawAddr = fmt.Sprintf("%x", line[16:])
+		}
+		return h, nil
+	}
+
+	tlvStart := 16 + addrBlockSize
+	if tlvStart < len(line) {
+		tlvs, err := decodeTLVs(line, line[tlvStart:], tlvStart)
+		if err != nil {
+			return nil, err
+		}
+		h.TLVs = tlvs
+	}
+
+	return h, nil
+}
+
+// trimNULs returns b as a string, truncated at the first NUL byte (the
+// padding convention used by UNIX address blocks).
+func trimNULs(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// unixAddr builds a *net.UnixAddr from a decoded socket path, or nil if the
+// path is empty.
+func unixAddr(path string) net.Addr {
+	if path == "" {
+		return nil
+	}
+	return &net.UnixAddr{Name: path, Net: "unix"}
+}
+
+// tcpAddr builds a *net.TCPAddr from decoded address/port strings, or nil if
+// they don't represent one (e.g. "UNSPEC").
+func tcpAddr(addr, port string) net.Addr {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: p}
+}
+
+// decodeTLVs walks a sequence of type-length-value records trailing a v2
+// address block. header is the full decoded header (used to verify
+// PP2_TYPE_CRC32C) and dataOffset is data's absolute offset within header.
+func decodeTLVs(header []byte, data []byte, dataOffset int) ([]TLV, error) {
+	var tlvs []TLV
+
+	i := 0
+	for i < len(data) {
+		if i+3 > len(data) {
+			return nil, fmt.Errorf("proxy-protocol v2 TLV header truncated")
+		}
+
+		t := data[i]
+		length := int(binary.BigEndian.Uint16(data[i+1 : i+3]))
+		if i+3+length > len(data) {
+			return nil, fmt.Errorf("proxy-protocol v2 TLV type 0x%02x declares length %d beyond header", t, length)
+		}
+		value := data[i+3 : i+3+length]
+
+		switch t {
+		case pp2TypeALPN:
+			tlvs = append(tlvs, TLV{Type: t, Name: "ALPN", Value: string(value)})
+		case pp2TypeAuthority:
+			tlvs = append(tlvs, TLV{Type: t, Name: "AUTHORITY", Value: string(value)})
+		case pp2TypeCRC32C:
+			tlvs = append(tlvs, TLV{Type: t, Name: "CRC32C", Value: verifyCRC32C(header, dataOffset+i+3, value)})
+		case pp2TypeNOOP:
+			// padding only, nothing to surface
+		case pp2TypeUniqueID:
+			tlvs = append(tlvs, TLV{Type: t, Name: "UNIQUE_ID", Value: fmt.Sprintf("%x", value)})
+		case pp2TypeSSL:
+			sslTLVs, err := decodeSSLTLV(value)
+			if err != nil {
+				return nil, err
+			}
+			tlvs = append(tlvs, sslTLVs...)
+		case pp2TypeNetNS:
+			tlvs = append(tlvs, TLV{Type: t, Name: "NETNS", Value: string(value)})
+		case pp2TypeAWS:
+			tlvs = append(tlvs, decodeAWSTLV(value))
+		default:
+			tlvs = append(tlvs, TLV{Type: t, Name: fmt.Sprintf("0x%02x", t), Value: fmt.Sprintf("%x", value)})
+		}
+
+		i += 3 + length
+	}
+
+	return tlvs, nil
+}
+
+// verifyCRC32C recomputes the Castagnoli CRC32 of header with the CRC32C
+// TLV's own value field (at valueOffset) zeroed, and reports whether it
+// matches the value carried on the wire.
+func verifyCRC32C(header []byte, valueOffset int, value []byte) string {
+	hex := fmt.Sprintf("%x", value)
+	if len(value) != 4 {
+		return hex + " (malformed CRC32C TLV)"
+	}
+
+	headerCopy := make([]byte, len(header))
+	copy(headerCopy, header)
+	for j := 0; j < 4; j++ {
+		headerCopy[valueOffset+j] = 0
+	}
+
+	want := binary.BigEndian.Uint32(value)
+	got := crc32.Checksum(headerCopy, crc32cTable)
+	if got != want {
+		return fmt.Sprintf("%s (CRC MISMATCH, computed %08x)", hex, got)
+	}
+	return hex
+}
+
+// decodeSSLTLV decodes a PP2_TYPE_SSL sub-block: a client flags byte, a
+// 4-byte verify field, then nested PP2_SUBTYPE_SSL_* TLVs.
+func decodeSSLTLV(value []byte) ([]TLV, error) {
+	if len(value) < 5 {
+		return nil, errors.New("proxy-protocol v2 SSL TLV too short")
+	}
+
+	flags := value[0]
+	verify := binary.BigEndian.Uint32(value[1:5])
+	tlvs := []TLV{{
+		Type:  pp2TypeSSL,
+		Name:  "SSL",
+		Value: fmt.Sprintf("client=0x%02x verify=%d", flags, verify),
+	}}
+
+	i := 0
+	sub := value[5:]
+	for i < len(sub) {
+		if i+3 > len(sub) {
+			return nil, errors.New("proxy-protocol v2 SSL sub-TLV header truncated")
+		}
+
+		st := sub[i]
+		length := int(binary.BigEndian.Uint16(sub[i+1 : i+3]))
+		if i+3+length > len(sub) {
+			return nil, fmt.Errorf("proxy-protocol v2 SSL sub-TLV 0x%02x declares length %d beyond header", st, length)
+		}
+		subValue := sub[i+3 : i+3+length]
+
+		switch st {
+		case pp2SubtypeSSLVersion:
+			tlvs = append(tlvs, TLV{Type: st, Name: "SSL_VERSION", Value: string(subValue)})
+		case pp2SubtypeSSLCN:
+			tlvs = append(tlvs, TLV{Type: st, Name: "SSL_CN", Value: string(subValue)})
+		case pp2SubtypeSSLCipher:
+			tlvs = append(tlvs, TLV{Type: st, Name: "SSL_CIPHER", Value: string(subValue)})
+		case pp2SubtypeSSLSigAlg:
+			tlvs = append(tlvs, TLV{Type: st, Name: "SSL_SIG_ALG", Value: string(subValue)})
+		case pp2SubtypeSSLKeyAlg:
+			tlvs = append(tlvs, TLV{Type: st, Name: "SSL_KEY_ALG", Value: string(subValue)})
+		default:
+			tlvs = append(tlvs, TLV{Type: st, Name: fmt.Sprintf("0x%02x", st), Value: fmt.Sprintf("%x", subValue)})
+		}
+
+		i += 3 + length
+	}
+
+	return tlvs, nil
+}
+
+// decodeAWSTLV decodes the AWS custom PP2_TYPE_AWS TLV, whose first value
+// byte identifies a sub-type (e.g. a vpce-... VPC endpoint ID).
+func decodeAWSTLV(value []byte) TLV {
+	if len(value) >= 1 && value[0] == pp2AWSSubtypeVPCE {
+		return TLV{Type: pp2TypeAWS, Name: "AWS_VPCE", Value: string(value[1:])}
+	}
+	return TLV{Type: pp2TypeAWS, Name: "AWS", Value: fmt.Sprintf("%x", value)}
+}
@@ -0,0 +1,189 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// buildV2Header assembles a minimal v2 header for testing: the 12-byte
+// signature, a version/command byte, an address-family/transport byte and
+// the given address block, with the length field set accordingly.
+func buildV2Header(t *testing.T, verCmd, afTp byte, addr []byte) []byte {
+	t.Helper()
+
+	buf := make([]byte, 0, 16+len(addr))
+	buf = append(buf, protocolV2SignatureBytes...)
+	buf = append(buf, verCmd, afTp)
+
+	lenField := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenField, uint16(len(addr)))
+	buf = append(buf, lenField...)
+	buf = append(buf, addr...)
+
+	return buf
+}
+
+func padPath(path string) []byte {
+	b := make([]byte, 108)
+	copy(b, path)
+	return b
+}
+
+// ipv6Addr builds a 36-byte v2 address block for src=::1, dst=::2,
+// srcPort=8080, dstPort=80.
+func ipv6Addr() []byte {
+	src := make([]byte, 16)
+	src[15] = 1
+	dst := make([]byte, 16)
+	dst[15] = 2
+
+	addr := append([]byte{}, src...)
+	addr = append(addr, dst...)
+	addr = append(addr, 0x1F, 0x90, 0x00, 0x50)
+	return addr
+}
+
+func TestParseV2AddressFamilies(t *testing.T) {
+	tests := []struct {
+		name         string
+		afTp         byte
+		addr         []byte
+		wantAddrType string
+		wantSrcAddr  string
+		wantDstAddr  string
+		wantSrcPort  string
+		wantDstPort  string
+		wantRawAddr  bool
+	}{
+		{
+			name:         "TCP over IPv4",
+			afTp:         0x11,
+			addr:         []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1F, 0x90, 0x00, 0x50},
+			wantAddrType: "AF_INET",
+			wantSrcAddr:  "10.0.0.1",
+			wantDstAddr:  "10.0.0.2",
+			wantSrcPort:  "8080",
+			wantDstPort:  "80",
+		},
+		{
+			name:         "TCP over IPv6",
+			afTp:         0x21,
+			addr:         ipv6Addr(),
+			wantAddrType: "AF_INET6",
+			wantSrcAddr:  "::1",
+			wantDstAddr:  "::2",
+			wantSrcPort:  "8080",
+			wantDstPort:  "80",
+		},
+		{
+			name:         "UNIX stream",
+			afTp:         0x31,
+			addr:         append(padPath("/tmp/src.sock"), padPath("/tmp/dst.sock")...),
+			wantAddrType: "AF_UNIX",
+			wantSrcAddr:  "/tmp/src.sock",
+			wantDstAddr:  "/tmp/dst.sock",
+			wantSrcPort:  "-",
+			wantDstPort:  "-",
+		},
+		{
+			name:         "UNIX datagram",
+			afTp:         0x32,
+			addr:         append(padPath("/tmp/src.sock"), padPath("/tmp/dst.sock")...),
+			wantAddrType: "AF_UNIX",
+			wantSrcAddr:  "/tmp/src.sock",
+			wantDstAddr:  "/tmp/dst.sock",
+			wantSrcPort:  "-",
+			wantDstPort:  "-",
+		},
+		{
+			name:         "AF_UNSPEC health check, no payload",
+			afTp:         0x00,
+			addr:         nil,
+			wantAddrType: "AF_UNSPEC",
+			wantSrcAddr:  "UNSPEC",
+			wantDstAddr:  "UNSPEC",
+			wantSrcPort:  "UNSPEC",
+			wantDstPort:  "UNSPEC",
+		},
+		{
+			name:         "AF_UNSPEC with stream bit and opaque payload",
+			afTp:         0x01,
+			addr:         []byte{0xDE, 0xAD, 0xBE, 0xEF},
+			wantAddrType: "AF_UNSPEC",
+			wantSrcAddr:  "UNSPEC",
+			wantDstAddr:  "UNSPEC",
+			wantSrcPort:  "UNSPEC",
+			wantDstPort:  "UNSPEC",
+			wantRawAddr:  true,
+		},
+		{
+			name:         "AF_UNSPEC with dgram bit and opaque payload",
+			afTp:         0x02,
+			addr:         []byte{0xCA, 0xFE},
+			wantAddrType: "AF_UNSPEC",
+			wantSrcAddr:  "UNSPEC",
+			wantDstAddr:  "UNSPEC",
+			wantSrcPort:  "UNSPEC",
+			wantDstPort:  "UNSPEC",
+			wantRawAddr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := buildV2Header(t, 0x21, tt.afTp, tt.addr)
+			h, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+			if err != nil {
+				t.Fatalf("ReadHeader() error = %v", err)
+			}
+
+			if h.AddrType != tt.wantAddrType {
+				t.Errorf("AddrType = %q, want %q", h.AddrType, tt.wantAddrType)
+			}
+			if h.SrcAddr != tt.wantSrcAddr {
+				t.Errorf("SrcAddr = %q, want %q", h.SrcAddr, tt.wantSrcAddr)
+			}
+			if h.DstAddr != tt.wantDstAddr {
+				t.Errorf("DstAddr = %q, want %q", h.DstAddr, tt.wantDstAddr)
+			}
+			if h.SrcPort != tt.wantSrcPort {
+				t.Errorf("SrcPort = %q, want %q", h.SrcPort, tt.wantSrcPort)
+			}
+			if h.DstPort != tt.wantDstPort {
+				t.Errorf("DstPort = %q, want %q", h.DstPort, tt.wantDstPort)
+			}
+			if tt.wantRawAddr && h.RawAddr == "" {
+				t.Errorf("RawAddr = %q, want non-empty hex dump", h.RawAddr)
+			}
+			if !tt.wantRawAddr && h.RawAddr != "" {
+				t.Errorf("RawAddr = %q, want empty", h.RawAddr)
+			}
+		})
+	}
+}
+
+func TestParseV2UnknownTransportProtocol(t *testing.T) {
+	raw := buildV2Header(t, 0x21, 0xFF, nil)
+	_, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err == nil {
+		t.Fatal("expected error for unknown address family/transport combination, got nil")
+	}
+}
+
+// TestParseV1PreservesFinalByte guards against a past bug where parseV1
+// trimmed the last byte off the line under the mistaken assumption that it
+// still needed to strip a trailing "\r" itself, even though
+// bufio.Reader.ReadLine already strips both "\r" and "\n". That truncated
+// the final byte of every v1 header, turning e.g. port "2000" into "200".
+func TestParseV1PreservesFinalByte(t *testing.T) {
+	raw := []byte("PROXY TCP4 10.0.0.1 10.0.0.2 1000 2000\r\n")
+	h, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	if h.DstPort != "2000" {
+		t.Errorf("DstPort = %q, want %q (final byte must not be truncated)", h.DstPort, "2000")
+	}
+}
@@ -0,0 +1,162 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultReadHeaderTimeout is used by Listener when ReadHeaderTimeout is
+// zero.
+const DefaultReadHeaderTimeout = 5 * time.Second
+
+// Policy controls how a Listener treats a connection from a given upstream
+// address. It is evaluated once per accepted connection, before any header
+// is read, so operators can require headers only from trusted L4 balancers.
+type Policy func(upstream net.Addr) (Decision, error)
+
+// Decision is the outcome of a Policy check for an accepted connection.
+type Decision int
+
+const (
+	// Use parses a PROXY header if present, and falls back to the
+	// underlying socket addresses otherwise.
+	Use Decision = iota
+	// Require parses a PROXY header and rejects the connection if none is
+	// present.
+	Require
+	// Ignore skips header parsing entirely and uses the underlying socket
+	// addresses.
+	Ignore
+	// Reject closes the connection without reading anything from it.
+	Reject
+)
+
+// Listener wraps a net.Listener, transparently parsing a PROXY protocol
+// header (v1 or v2) from each accepted connection and reflecting the
+// PROXY-conveyed addresses in Conn's RemoteAddr/LocalAddr.
+type Listener struct {
+	net.Listener
+
+	// ReadHeaderTimeout bounds how long Accept will block reading a header
+	// off a connection before giving up. Defaults to
+	// DefaultReadHeaderTimeout.
+	ReadHeaderTimeout time.Duration
+
+	// Policy decides, per upstream address, whether to require, use,
+	// ignore or reject a PROXY header. A nil Policy is equivalent to
+	// always returning Use.
+	Policy Policy
+
+	// MaxHeaderBytes caps the size of a v2 header Accept will allocate a
+	// buffer for. Defaults to DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+}
+
+// Accept waits for and returns the next connection, parsing its PROXY
+// protocol header according to Policy.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	decision := Use
+	if l.Policy != nil {
+		decision, err = l.Policy(conn.RemoteAddr())
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxyproto: policy rejected %s: %w", conn.RemoteAddr(), err)
+		}
+	}
+
+	if decision == Reject {
+		conn.Close()
+		return nil, fmt.Errorf("proxyproto: policy rejected connection from %s", conn.RemoteAddr())
+	}
+
+	if decision == Ignore {
+		return conn, nil
+	}
+
+	timeout := l.ReadHeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultReadHeaderTimeout
+	}
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	maxHeaderBytes := l.MaxHeaderBytes
+	if maxHeaderBytes == 0 {
+		maxHeaderBytes = DefaultMaxHeaderBytes
+	}
+
+	bufReader := bufio.NewReader(conn)
+	header, err := ReadHeaderLimit(bufReader, maxHeaderBytes)
+	if err != nil {
+		if !errors.Is(err, ErrNoProxyHeader) {
+			conn.Close()
+			return nil, err
+		}
+		if decision == Require {
+			conn.Close()
+			return nil, fmt.Errorf("proxyproto: required PROXY header missing from %s", conn.RemoteAddr())
+		}
+		header = nil
+	}
+
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	return newConn(conn, bufReader, header), nil
+}
+
+// Conn wraps a net.Conn accepted by Listener, serving bytes after the
+// PROXY header (if any) and reporting the PROXY-conveyed addresses.
+type Conn struct {
+	net.Conn
+
+	bufReader *bufio.Reader
+	header    *Header
+}
+
+func newConn(conn net.Conn, bufReader *bufio.Reader, header *Header) *Conn {
+	return &Conn{Conn: conn, bufReader: bufReader, header: header}
+}
+
+// Read implements net.Conn, returning payload bytes following the PROXY
+// header.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.bufReader.Read(b)
+}
+
+// Header returns the PROXY header parsed for this connection, or nil if
+// none was present.
+func (c *Conn) Header() *Header {
+	return c.header
+}
+
+// RemoteAddr returns the PROXY-conveyed source address, falling back to the
+// underlying socket's remote address when no header was parsed or the
+// header carries the LOCAL command (a health check from the balancer
+// itself, conveying no real client address).
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.header != nil && c.header.Command != "LOCAL" && c.header.Src != nil {
+		return c.header.Src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the PROXY-conveyed destination address, falling back to
+// the underlying socket's local address when no header was parsed or the
+// header carries the LOCAL command.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.header != nil && c.header.Command != "LOCAL" && c.header.Dst != nil {
+		return c.header.Dst
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,222 @@
+package proxyproto
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chanListener is a net.Listener backed by a channel of pre-established
+// connections, used to drive Listener.Accept against synthetic conns
+// without binding a real socket.
+type chanListener struct {
+	conns chan net.Conn
+}
+
+func newChanListener(conn net.Conn) *chanListener {
+	l := &chanListener{conns: make(chan net.Conn, 1)}
+	l.conns <- conn
+	return l
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, errors.New("chanListener: closed")
+	}
+	return c, nil
+}
+
+func (l *chanListener) Close() error {
+	close(l.conns)
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr { return fakeAddr("chanListener") }
+
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "fake" }
+func (a fakeAddr) String() string  { return string(a) }
+
+func TestListenerAcceptUseDecodesHeaderAndFallsBackWithoutOne(t *testing.T) {
+	t.Run("header present", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		raw, err := EncodeV1(Header{AddrType: "TCP4", SrcAddr: "10.0.0.1", SrcPort: "1000", DstAddr: "10.0.0.2", DstPort: "2000"})
+		if err != nil {
+			t.Fatalf("EncodeV1() error = %v", err)
+		}
+		go func() {
+			client.Write(raw)
+			client.Write([]byte("hello"))
+		}()
+
+		l := &Listener{Listener: newChanListener(server)}
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept() error = %v", err)
+		}
+		defer conn.Close()
+
+		pc, ok := conn.(*Conn)
+		if !ok {
+			t.Fatalf("Accept() returned %T, want *Conn", conn)
+		}
+		if pc.Header() == nil {
+			t.Fatal("Header() = nil, want a decoded header")
+		}
+		if got, want := conn.RemoteAddr().String(), "10.0.0.1:1000"; got != want {
+			t.Errorf("RemoteAddr() = %q, want %q", got, want)
+		}
+
+		payload := make([]byte, 5)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			t.Fatalf("reading payload: %v", err)
+		}
+		if string(payload) != "hello" {
+			t.Errorf("payload = %q, want %q", payload, "hello")
+		}
+	})
+
+	t.Run("no header", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		go client.Write([]byte("GET / HTTP/1.0\r\n"))
+
+		l := &Listener{Listener: newChanListener(server)}
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept() error = %v", err)
+		}
+		defer conn.Close()
+
+		pc, ok := conn.(*Conn)
+		if !ok {
+			t.Fatalf("Accept() returned %T, want *Conn", conn)
+		}
+		if pc.Header() != nil {
+			t.Fatalf("Header() = %+v, want nil", pc.Header())
+		}
+		if got, want := conn.RemoteAddr(), server.RemoteAddr(); got.String() != want.String() {
+			t.Errorf("RemoteAddr() = %v, want underlying socket addr %v", got, want)
+		}
+	})
+}
+
+func TestListenerAcceptRequireRejectsMissingHeader(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go client.Write([]byte("GET / HTTP/1.0\r\n"))
+
+	l := &Listener{
+		Listener: newChanListener(server),
+		Policy:   func(net.Addr) (Decision, error) { return Require, nil },
+	}
+	_, err := l.Accept()
+	if err == nil {
+		t.Fatal("Accept() error = nil, want an error for a missing required header")
+	}
+	if !strings.Contains(err.Error(), "required PROXY header missing") {
+		t.Errorf("Accept() error = %v, want it to mention the missing required header", err)
+	}
+}
+
+func TestListenerAcceptIgnoreSkipsParsing(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	raw, err := EncodeV1(Header{AddrType: "TCP4", SrcAddr: "10.0.0.1", SrcPort: "1000", DstAddr: "10.0.0.2", DstPort: "2000"})
+	if err != nil {
+		t.Fatalf("EncodeV1() error = %v", err)
+	}
+	go client.Write(raw)
+
+	l := &Listener{
+		Listener: newChanListener(server),
+		Policy:   func(net.Addr) (Decision, error) { return Ignore, nil },
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*Conn); ok {
+		t.Fatal("Accept() wrapped the conn in *Conn, want the raw underlying conn for Ignore")
+	}
+	if got, want := conn.RemoteAddr(), server.RemoteAddr(); got.String() != want.String() {
+		t.Errorf("RemoteAddr() = %v, want underlying socket addr %v", got, want)
+	}
+}
+
+func TestListenerAcceptRejectClosesConnWithoutReading(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	l := &Listener{
+		Listener: newChanListener(server),
+		Policy:   func(net.Addr) (Decision, error) { return Reject, nil },
+	}
+	_, err := l.Accept()
+	if err == nil {
+		t.Fatal("Accept() error = nil, want an error for a rejected connection")
+	}
+	if !strings.Contains(err.Error(), "policy rejected") {
+		t.Errorf("Accept() error = %v, want it to mention the policy rejection", err)
+	}
+
+	client.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Error("write on client side succeeded, want the server conn to already be closed")
+	}
+}
+
+func TestListenerAcceptPolicyErrorClosesConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	wantErr := errors.New("no soup for you")
+	l := &Listener{
+		Listener: newChanListener(server),
+		Policy:   func(net.Addr) (Decision, error) { return Use, wantErr },
+	}
+	_, err := l.Accept()
+	if err == nil || !errors.Is(err, wantErr) {
+		t.Fatalf("Accept() error = %v, want it to wrap %v", err, wantErr)
+	}
+}
+
+func TestConnRemoteLocalAddrLOCALFallback(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	header := &Header{
+		Command: "LOCAL",
+		Src:     &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000},
+		Dst:     &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2000},
+	}
+	c := newConn(server, nil, header)
+
+	if got, want := c.RemoteAddr().String(), server.RemoteAddr().String(); got != want {
+		t.Errorf("RemoteAddr() for a LOCAL command = %q, want the underlying socket addr %q", got, want)
+	}
+	if got, want := c.LocalAddr().String(), server.LocalAddr().String(); got != want {
+		t.Errorf("LocalAddr() for a LOCAL command = %q, want the underlying socket addr %q", got, want)
+	}
+
+	header.Command = "PROXY"
+	if got, want := c.RemoteAddr().String(), header.Src.String(); got != want {
+		t.Errorf("RemoteAddr() for a PROXY command = %q, want the header's source addr %q", got, want)
+	}
+	if got, want := c.LocalAddr().String(), header.Dst.String(); got != want {
+		t.Errorf("LocalAddr() for a PROXY command = %q, want the header's destination addr %q", got, want)
+	}
+}
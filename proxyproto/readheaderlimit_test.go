@@ -0,0 +1,83 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadHeaderNoProxyHeaderShortData(t *testing.T) {
+	// A short, complete HTTP request line is well under the 16 bytes
+	// ReadHeader used to require before it would even look at the prefix.
+	raw := []byte("GET / HTTP/1.0\r\n")
+	_, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if !errors.Is(err, ErrNoProxyHeader) {
+		t.Fatalf("ReadHeader() error = %v, want ErrNoProxyHeader", err)
+	}
+}
+
+func TestReadHeaderNoProxyHeaderPartialV2Prefix(t *testing.T) {
+	// A stream that starts out looking like a v2 signature but ends (EOF)
+	// before enough bytes arrive to confirm or rule it out.
+	raw := protocolV2SignatureBytes[:8]
+	_, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if !errors.Is(err, ErrNoProxyHeader) {
+		t.Fatalf("ReadHeader() error = %v, want ErrNoProxyHeader", err)
+	}
+}
+
+func TestReadHeaderLimitRejectsOversizedLength(t *testing.T) {
+	raw := buildV2Header(t, 0x21, 0x11, make([]byte, 512))
+	_, err := ReadHeaderLimit(bufio.NewReader(bytes.NewReader(raw)), 256)
+	if err == nil {
+		t.Fatal("expected error for v2 header length exceeding maxHeaderBytes, got nil")
+	}
+	if errors.Is(err, ErrNoProxyHeader) {
+		t.Errorf("got ErrNoProxyHeader, want a real error rejecting the oversized length")
+	}
+}
+
+func TestParseV2RejectsShortAddressBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		verCmd byte
+		afTp   byte
+		addr   []byte
+	}{
+		{"AF_INET", 0x21, 0x11, []byte{1, 2, 3, 4}},
+		{"AF_INET6", 0x21, 0x21, []byte{1, 2, 3, 4}},
+		{"AF_UNIX", 0x21, 0x31, []byte{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := buildV2Header(t, tt.verCmd, tt.afTp, tt.addr)
+			_, err := ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+			if err == nil {
+				t.Fatalf("expected error for truncated %s address block, got nil", tt.name)
+			}
+		})
+	}
+}
+
+// erroringReader always returns a non-EOF error, modeling a read deadline
+// exceeded mid-signature.
+type erroringReader struct{}
+
+func (erroringReader) Read([]byte) (int, error) {
+	return 0, errors.New("deadline exceeded")
+}
+
+func TestReadHeaderPropagatesNonEOFPeekError(t *testing.T) {
+	_, err := ReadHeader(bufio.NewReader(erroringReader{}))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if errors.Is(err, ErrNoProxyHeader) {
+		t.Errorf("got ErrNoProxyHeader, want the underlying read error to propagate")
+	}
+	if errors.Is(err, io.EOF) {
+		t.Errorf("got io.EOF, want the underlying non-EOF read error")
+	}
+}